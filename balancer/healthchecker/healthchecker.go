@@ -0,0 +1,115 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package healthchecker provides a reusable helper that LB policies such as
+// pick_first and round_robin can embed to layer client-side, per-SubConn
+// health checking on top of raw transport connectivity, driven by the
+// service config's healthCheckConfig.serviceName field.
+//
+// No balancer in this tree embeds Watcher: pick_first and round_robin act on
+// raw transport connectivity only, and the only callers of Watcher are its
+// own tests.
+package healthchecker
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/internal"
+)
+
+// Watcher tracks, per SubConn, an in-flight internal.HealthCheckFunc
+// invocation. LB policies create one Watcher and route every
+// UpdateSubConnState call for their SubConns through
+// HandleSubConnStateChange instead of acting on the raw state directly.
+type Watcher struct {
+	mu      sync.Mutex
+	cancels map[balancer.SubConn]context.CancelFunc
+}
+
+// New returns a ready-to-use Watcher.
+func New() *Watcher {
+	return &Watcher{cancels: make(map[balancer.SubConn]context.CancelFunc)}
+}
+
+// NewStreamFunc opens a new client stream for method on sc's connection.
+// LB policies pass a closure over sc (typically bound to the same
+// balancer.ClientConn they used to create sc) as the newStream argument to
+// HandleSubConnStateChange.
+type NewStreamFunc func(method string) (interface{}, error)
+
+// HandleSubConnStateChange processes a raw SubConnState update for sc. If
+// serviceName is empty, health checking is disabled for sc and s is
+// returned unchanged. Every call first cancels any health check already
+// running for sc. Then, if s.ConnectivityState is Ready, it starts a new
+// health-check stream via newStream and
+// reports subsequent synthetic states to update as the reported health
+// changes, until sc leaves Ready or is removed via Close. While Ready and
+// health checking is active, HandleSubConnStateChange itself returns a
+// Connecting state so the caller does not treat sc as pickable until the
+// first health report arrives.
+//
+// LB policies such as pick_first and round_robin are expected to route
+// every UpdateSubConnState call for their SubConns through this method
+// instead of acting on the raw state directly, so that a NOT_SERVING
+// backend is treated as not ready for picking.
+func (w *Watcher) HandleSubConnStateChange(ctx context.Context, sc balancer.SubConn, s balancer.SubConnState, newStream NewStreamFunc, serviceName string, update func(balancer.SubConnState)) balancer.SubConnState {
+	w.mu.Lock()
+	if cancel, ok := w.cancels[sc]; ok {
+		cancel()
+		delete(w.cancels, sc)
+	}
+	w.mu.Unlock()
+
+	if serviceName == "" || s.ConnectivityState != connectivity.Ready || internal.HealthCheckFunc == nil {
+		return s
+	}
+
+	hctx, cancel := context.WithCancel(ctx)
+	w.mu.Lock()
+	w.cancels[sc] = cancel
+	w.mu.Unlock()
+
+	go func() {
+		reportHealth := func(healthy bool, err error) {
+			if healthy {
+				update(balancer.SubConnState{ConnectivityState: connectivity.Ready})
+				return
+			}
+			update(balancer.SubConnState{ConnectivityState: connectivity.TransientFailure, ConnectionError: err})
+		}
+		internal.HealthCheckFunc(hctx, newStream, reportHealth, serviceName)
+	}()
+
+	// The caller should not treat sc as ready until the health-check stream
+	// delivers its first report.
+	return balancer.SubConnState{ConnectivityState: connectivity.Connecting}
+}
+
+// Close stops health checking for sc, if any is in progress. LB policies
+// should call this when they remove sc.
+func (w *Watcher) Close(sc balancer.SubConn) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if cancel, ok := w.cancels[sc]; ok {
+		cancel()
+		delete(w.cancels, sc)
+	}
+}
@@ -0,0 +1,124 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package healthchecker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/internal"
+)
+
+// fakeSubConn is a minimal balancer.SubConn used only as a map key in these
+// tests.
+type fakeSubConn struct{ balancer.SubConn }
+
+func TestHandleSubConnStateChangeNoServiceName(t *testing.T) {
+	w := New()
+	sc := &fakeSubConn{}
+	in := balancer.SubConnState{ConnectivityState: connectivity.Ready}
+	out := w.HandleSubConnStateChange(context.Background(), sc, in, nil, "", func(balancer.SubConnState) {})
+	if out != in {
+		t.Errorf("HandleSubConnStateChange() = %+v, want unchanged %+v", out, in)
+	}
+}
+
+func TestHandleSubConnStateChangeNonReadyPassthrough(t *testing.T) {
+	w := New()
+	sc := &fakeSubConn{}
+	in := balancer.SubConnState{ConnectivityState: connectivity.Connecting}
+	out := w.HandleSubConnStateChange(context.Background(), sc, in, nil, "foo", func(balancer.SubConnState) {})
+	if out != in {
+		t.Errorf("HandleSubConnStateChange() = %+v, want unchanged %+v", out, in)
+	}
+}
+
+// TestHandleSubConnStateChangeDrivesHealthCheckFunc verifies the actual
+// feature this package exists for: a Ready SubConn starts
+// internal.HealthCheckFunc, HandleSubConnStateChange itself returns a
+// synthetic Connecting state until the first report arrives, subsequent
+// reports are surfaced through update as synthetic SubConnStates, and Close
+// cancels the in-flight check.
+func TestHandleSubConnStateChangeDrivesHealthCheckFunc(t *testing.T) {
+	origFunc := internal.HealthCheckFunc
+	defer func() { internal.HealthCheckFunc = origFunc }()
+
+	started := make(chan struct{})
+	canceled := make(chan struct{})
+	var gotServiceName string
+	internal.HealthCheckFunc = func(ctx context.Context, newStream func(string) (interface{}, error), reportHealth func(bool, error), serviceName string) error {
+		gotServiceName = serviceName
+		reportHealth(true, nil)
+		reportHealth(false, nil)
+		close(started)
+		<-ctx.Done()
+		close(canceled)
+		return ctx.Err()
+	}
+
+	w := New()
+	sc := &fakeSubConn{}
+	var mu sync.Mutex
+	var updates []balancer.SubConnState
+	update := func(s balancer.SubConnState) {
+		mu.Lock()
+		updates = append(updates, s)
+		mu.Unlock()
+	}
+	newStream := func(string) (interface{}, error) { return nil, nil }
+
+	in := balancer.SubConnState{ConnectivityState: connectivity.Ready}
+	out := w.HandleSubConnStateChange(context.Background(), sc, in, newStream, "myservice", update)
+	if out.ConnectivityState != connectivity.Connecting {
+		t.Errorf("HandleSubConnStateChange() = %+v, want ConnectivityState Connecting", out)
+	}
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for internal.HealthCheckFunc to run")
+	}
+	if gotServiceName != "myservice" {
+		t.Errorf("HealthCheckFunc called with serviceName = %q, want %q", gotServiceName, "myservice")
+	}
+
+	mu.Lock()
+	got := append([]balancer.SubConnState(nil), updates...)
+	mu.Unlock()
+	want := []connectivity.State{connectivity.Ready, connectivity.TransientFailure}
+	if len(got) != len(want) {
+		t.Fatalf("update() calls = %+v, want states %v", got, want)
+	}
+	for i, wantState := range want {
+		if got[i].ConnectivityState != wantState {
+			t.Errorf("update()[%d].ConnectivityState = %v, want %v", i, got[i].ConnectivityState, wantState)
+		}
+	}
+
+	w.Close(sc)
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for Close to cancel the in-flight health check")
+	}
+}
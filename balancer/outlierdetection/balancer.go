@@ -0,0 +1,447 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package outlierdetection
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/resolver"
+)
+
+const (
+	defaultInterval           = 10 * time.Second
+	defaultBaseEjectionTime   = 30 * time.Second
+	defaultMaxEjectionTime    = 300 * time.Second
+	defaultMaxEjectionPercent = 10
+)
+
+var logger = grpclog.Component("outlier-detection")
+
+func init() {
+	balancer.Register(bb{})
+}
+
+// internalDuration wraps time.Duration to accept the xDS/JSON string
+// encoding ("10s") used in service config.
+type internalDuration struct {
+	time.Duration
+}
+
+func (d *internalDuration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	dur, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = dur
+	return nil
+}
+
+// internalServiceConfig is the standard gRPC LoadBalancingConfig list
+// encoding used for the wrapped child policy, e.g. [{"round_robin":{}}].
+type internalServiceConfig []map[string]json.RawMessage
+
+type bb struct{}
+
+func (bb) Name() string { return Name }
+
+func (bb) Build(cc balancer.ClientConn, bOpts balancer.BuildOptions) balancer.Balancer {
+	b := &outlierDetectionBalancer{
+		cc:       cc,
+		bOpts:    bOpts,
+		addrInfo: make(map[resolver.Address]*addressInfo),
+		scWraps:  make(map[balancer.SubConn]*subConnWrapper),
+		closed:   make(chan struct{}),
+	}
+	b.child = &noopChild{}
+	return b
+}
+
+// outlierDetectionBalancer wraps a child balancer, tracking per-address
+// success/failure counts from a wrapped Done callback and periodically
+// ejecting addresses that deviate from the cluster.
+type outlierDetectionBalancer struct {
+	cc    balancer.ClientConn
+	bOpts balancer.BuildOptions
+
+	mu        sync.Mutex
+	cfg       *LBConfig
+	child     balancer.Balancer
+	childName string
+	addrInfo  map[resolver.Address]*addressInfo
+	scWraps   map[balancer.SubConn]*subConnWrapper
+
+	timerMu sync.Mutex
+	timer   *time.Timer
+	closed  chan struct{}
+}
+
+// noopChild is a placeholder used before the first UpdateClientConnState
+// configures a real child policy.
+type noopChild struct{}
+
+func (noopChild) UpdateClientConnState(balancer.ClientConnState) error       { return nil }
+func (noopChild) ResolverError(error)                                        {}
+func (noopChild) UpdateSubConnState(balancer.SubConn, balancer.SubConnState) {}
+func (noopChild) Close()                                                     {}
+
+func (b *outlierDetectionBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	cfg, ok := s.BalancerConfig.(*LBConfig)
+	if !ok {
+		return fmt.Errorf("outlierdetection: unexpected balancer config type %T", s.BalancerConfig)
+	}
+
+	b.mu.Lock()
+	first := b.cfg == nil
+	b.cfg = cfg
+	current := make(map[resolver.Address]bool, len(s.ResolverState.Addresses))
+	for _, a := range s.ResolverState.Addresses {
+		current[a] = true
+		if _, ok := b.addrInfo[a]; !ok {
+			b.addrInfo[a] = &addressInfo{}
+		}
+	}
+	for a := range b.addrInfo {
+		if !current[a] {
+			delete(b.addrInfo, a)
+		}
+	}
+	var childName string
+	var childCfg json.RawMessage
+	for _, e := range *cfg.ChildPolicy {
+		for name, c := range e {
+			childName, childCfg = name, c
+		}
+	}
+	builder := balancer.Get(childName)
+	if builder == nil {
+		b.mu.Unlock()
+		return fmt.Errorf("outlierdetection: unregistered child policy %q", childName)
+	}
+	var oldChild balancer.Balancer
+	switch {
+	case first:
+		b.childName = childName
+		b.child = builder.Build(&odClientConn{b: b}, b.bOpts)
+	case childName != b.childName:
+		// The child policy named in the config changed since the last
+		// update; keeping the old child running under the new child's
+		// config would apply one policy's config to another policy's
+		// balancer, so rebuild instead of silently ignoring the change.
+		logger.Warningf("outlierdetection: child policy changed from %q to %q; rebuilding child balancer", b.childName, childName)
+		oldChild = b.child
+		b.childName = childName
+		b.child = builder.Build(&odClientConn{b: b}, b.bOpts)
+	}
+	if pc, ok := builder.(balancer.ConfigParser); ok && len(childCfg) > 0 {
+		parsed, err := pc.ParseConfig(childCfg)
+		if err == nil {
+			s.BalancerConfig = parsed
+		}
+	}
+	b.mu.Unlock()
+
+	// oldChild.Close() must happen outside b.mu: Close may synchronously
+	// call back into b's wrapped ClientConn (e.g. RemoveSubConn), which
+	// re-acquires b.mu.
+	if oldChild != nil {
+		oldChild.Close()
+	}
+
+	b.updateEjectionTimer(cfg)
+	return b.child.UpdateClientConnState(s)
+}
+
+func (b *outlierDetectionBalancer) ResolverError(err error) {
+	b.mu.Lock()
+	child := b.child
+	b.mu.Unlock()
+	child.ResolverError(err)
+}
+
+// UpdateSubConnState is invoked by gRPC with the real transport
+// connectivity state of sc. It records the state on sc's wrapper and, if sc
+// is currently ejected, substitutes a synthetic TransientFailure before
+// forwarding to the child so the child never picks an ejected SubConn while
+// still leaving the real transport connected underneath.
+func (b *outlierDetectionBalancer) UpdateSubConnState(sc balancer.SubConn, s balancer.SubConnState) {
+	b.mu.Lock()
+	scw, ok := b.scWraps[unwrap(sc)]
+	if !ok {
+		child := b.child
+		b.mu.Unlock()
+		child.UpdateSubConnState(sc, s)
+		return
+	}
+	scw.latestState = s
+	ejected := scw.ejected
+	child := b.child
+	b.mu.Unlock()
+
+	if ejected {
+		s = balancer.SubConnState{ConnectivityState: connectivity.TransientFailure, ConnectionError: errEjected}
+	}
+	child.UpdateSubConnState(scw, s)
+}
+
+func (b *outlierDetectionBalancer) Close() {
+	close(b.closed)
+	b.timerMu.Lock()
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	b.timerMu.Unlock()
+	b.mu.Lock()
+	child := b.child
+	b.mu.Unlock()
+	child.Close()
+}
+
+// updateEjectionTimer (re)starts the periodic ejection sweep at cfg.Interval
+// if either detection algorithm is configured.
+func (b *outlierDetectionBalancer) updateEjectionTimer(cfg *LBConfig) {
+	b.timerMu.Lock()
+	defer b.timerMu.Unlock()
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	if cfg.SuccessRateEjection == nil && cfg.FailurePercentageEjection == nil {
+		return
+	}
+	interval := cfg.Interval.Duration
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	b.timer = time.AfterFunc(interval, func() { b.runSweepAndReschedule(cfg) })
+}
+
+func (b *outlierDetectionBalancer) runSweepAndReschedule(cfg *LBConfig) {
+	select {
+	case <-b.closed:
+		return
+	default:
+	}
+	b.sweep(cfg)
+	b.updateEjectionTimer(cfg)
+}
+
+// sweep runs the configured detection algorithms once over the current
+// address set, ejecting or un-ejecting SubConns as needed.
+func (b *outlierDetectionBalancer) sweep(cfg *LBConfig) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, ai := range b.addrInfo {
+		ai.callCounter.swap()
+	}
+
+	if cfg.SuccessRateEjection != nil {
+		applySuccessRateEjection(b.addrInfo, cfg.SuccessRateEjection)
+	}
+	if cfg.FailurePercentageEjection != nil {
+		applyFailurePercentageEjection(b.addrInfo, cfg.FailurePercentageEjection)
+	}
+
+	b.enforceEjections(cfg)
+	b.maybeUneject(cfg)
+}
+
+// enforceEjections ejects every address marked for ejection by an
+// algorithm this round, subject to the cluster-wide MaxEjectionPercent cap,
+// and notifies the child that the corresponding SubConns are unusable.
+func (b *outlierDetectionBalancer) enforceEjections(cfg *LBConfig) {
+	total := len(b.addrInfo)
+	if total == 0 {
+		return
+	}
+	maxEjected := (int(cfg.MaxEjectionPercent) * total) / 100
+	alreadyEjected := 0
+	for _, ai := range b.addrInfo {
+		if ai.ejected {
+			alreadyEjected++
+		}
+	}
+	for addr, ai := range b.addrInfo {
+		pending := ai.pendingEjection
+		ai.pendingEjection = false
+		if !pending || ai.ejected {
+			continue
+		}
+		if alreadyEjected >= maxEjected {
+			continue
+		}
+		ai.ejected = true
+		ai.ejectionCount++
+		ai.lastEjectionTimestamp = time.Now()
+		alreadyEjected++
+		for _, scw := range b.scWraps {
+			if scw.addr == addr {
+				scw.ejected = true
+				b.child.UpdateSubConnState(scw, balancer.SubConnState{ConnectivityState: connectivity.TransientFailure, ConnectionError: errEjected})
+			}
+		}
+	}
+}
+
+// maybeUneject re-admits addresses whose ejection duration
+// (baseEjectionTime * ejectionCount, capped at maxEjectionTime) has
+// elapsed.
+func (b *outlierDetectionBalancer) maybeUneject(cfg *LBConfig) {
+	base := cfg.BaseEjectionTime.Duration
+	if base <= 0 {
+		base = defaultBaseEjectionTime
+	}
+	max := cfg.MaxEjectionTime.Duration
+	if max <= 0 {
+		max = defaultMaxEjectionTime
+	}
+	for addr, ai := range b.addrInfo {
+		if !ai.ejected {
+			continue
+		}
+		dur := base * time.Duration(ai.ejectionCount)
+		if dur > max {
+			dur = max
+		}
+		if time.Since(ai.lastEjectionTimestamp) < dur {
+			continue
+		}
+		ai.ejected = false
+		for _, scw := range b.scWraps {
+			if scw.addr == addr {
+				scw.ejected = false
+				b.child.UpdateSubConnState(scw, scw.latestState)
+			}
+		}
+	}
+}
+
+// odClientConn is the balancer.ClientConn passed to the child balancer; it
+// wraps every created SubConn so per-address call outcomes can be tracked
+// and eject/uneject can be applied without the child's involvement.
+type odClientConn struct {
+	b *outlierDetectionBalancer
+}
+
+func (o *odClientConn) NewSubConn(addrs []resolver.Address, opts balancer.NewSubConnOptions) (balancer.SubConn, error) {
+	sc, err := o.b.cc.NewSubConn(addrs, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return sc, nil
+	}
+	scw := &subConnWrapper{SubConn: sc}
+	o.b.mu.Lock()
+	scw.addr = addrs[0]
+	o.b.scWraps[sc] = scw
+	o.b.mu.Unlock()
+	return scw, nil
+}
+
+func (o *odClientConn) RemoveSubConn(sc balancer.SubConn) {
+	o.b.mu.Lock()
+	delete(o.b.scWraps, unwrap(sc))
+	o.b.mu.Unlock()
+	o.b.cc.RemoveSubConn(unwrap(sc))
+}
+
+func (o *odClientConn) UpdateAddresses(sc balancer.SubConn, addrs []resolver.Address) {
+	o.b.cc.UpdateAddresses(unwrap(sc), addrs)
+}
+
+func (o *odClientConn) UpdateState(s balancer.State) {
+	picker := s.Picker
+	s.Picker = &wrappedPicker{p: picker, b: o.b}
+	o.b.cc.UpdateState(s)
+}
+
+func (o *odClientConn) ResolveNow(opts resolver.ResolveNowOptions) { o.b.cc.ResolveNow(opts) }
+func (o *odClientConn) Target() string                             { return o.b.cc.Target() }
+
+func unwrap(sc balancer.SubConn) balancer.SubConn {
+	if scw, ok := sc.(*subConnWrapper); ok {
+		return scw.SubConn
+	}
+	return sc
+}
+
+// wrappedPicker intercepts every pick's Done callback so the result can be
+// recorded against the picked address's call counter before being
+// forwarded to the original caller-supplied Done, if any.
+type wrappedPicker struct {
+	p balancer.Picker
+	b *outlierDetectionBalancer
+}
+
+func (wp *wrappedPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	res, err := wp.p.Pick(info)
+	if err != nil {
+		return res, err
+	}
+	scw, ok := res.SubConn.(*subConnWrapper)
+	if !ok {
+		return res, nil
+	}
+	res.SubConn = scw.SubConn
+	childDone := res.Done
+	res.Done = func(di balancer.DoneInfo) {
+		wp.b.recordCallResult(scw.addr, di.Err == nil)
+		if childDone != nil {
+			childDone(di)
+		}
+	}
+	return res, nil
+}
+
+func (b *outlierDetectionBalancer) recordCallResult(addr resolver.Address, success bool) {
+	b.mu.Lock()
+	ai, ok := b.addrInfo[addr]
+	b.mu.Unlock()
+	if !ok {
+		return
+	}
+	if success {
+		ai.callCounter.onSuccess()
+	} else {
+		ai.callCounter.onFailure()
+	}
+}
+
+// subConnWrapper associates a child-created SubConn with the address it
+// backs, so ejection can be applied by address, and can substitute a
+// synthetic TransientFailure state while ejected without tearing down the
+// underlying connection.
+type subConnWrapper struct {
+	balancer.SubConn
+	addr        resolver.Address
+	latestState balancer.SubConnState
+	ejected     bool
+}
+
+var errEjected = fmt.Errorf("outlierdetection: subchannel ejected")
@@ -0,0 +1,89 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package outlierdetection
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// bucket holds the raw success/failure tallies for one measurement
+// interval.
+type bucket struct {
+	success uint64
+	failure uint64
+}
+
+// callCounter accumulates call results into an active bucket while the
+// ejection algorithms read from the previous interval's inactive bucket,
+// swapped atomically once per Interval so in-flight RPCs never corrupt a
+// bucket that is being analyzed.
+type callCounter struct {
+	active   bucket
+	inactive bucket
+}
+
+func (c *callCounter) onSuccess() { atomic.AddUint64(&c.active.success, 1) }
+func (c *callCounter) onFailure() { atomic.AddUint64(&c.active.failure, 1) }
+
+// swap moves the active bucket into inactive (for this round's analysis)
+// and resets active to start accumulating the next interval's results.
+func (c *callCounter) swap() {
+	c.inactive = bucket{
+		success: atomic.SwapUint64(&c.active.success, 0),
+		failure: atomic.SwapUint64(&c.active.failure, 0),
+	}
+}
+
+// addressInfo tracks call outcomes and ejection state for a single address
+// across the lifetime of the balancer.
+type addressInfo struct {
+	callCounter callCounter
+
+	ejected               bool
+	pendingEjection       bool
+	ejectionCount         int
+	lastEjectionTimestamp time.Time
+}
+
+// requestVolume returns the total number of calls recorded in the interval
+// under analysis.
+func (ai *addressInfo) requestVolume() uint64 {
+	return ai.callCounter.inactive.success + ai.callCounter.inactive.failure
+}
+
+// successRate returns the fraction (0-1) of calls that succeeded in the
+// interval under analysis.
+func (ai *addressInfo) successRate() float64 {
+	total := ai.requestVolume()
+	if total == 0 {
+		return 1
+	}
+	return float64(ai.callCounter.inactive.success) / float64(total)
+}
+
+// failurePercentage returns the percentage (0-100) of calls that failed in
+// the interval under analysis.
+func (ai *addressInfo) failurePercentage() float64 {
+	total := ai.requestVolume()
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(ai.callCounter.inactive.failure) / float64(total)
+}
@@ -0,0 +1,169 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package outlierdetection implements a balancer that wraps a child
+// balancer and periodically ejects SubConns whose observed call results
+// deviate from the rest of the cluster, per the xDS OutlierDetection
+// specification.
+package outlierdetection
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/grpc/serviceconfig"
+)
+
+// Name is the name of the outlier_detection balancer policy.
+const Name = "outlier_detection"
+
+const (
+	defaultStdevFactor                    = 1900
+	defaultEnforcementPercentage          = 100
+	defaultSuccessRateMinimumHosts        = 5
+	defaultSuccessRateRequestVolume       = 100
+	defaultFailurePercentageThreshold     = 85
+	defaultFailurePercentageMinimumHosts  = 5
+	defaultFailurePercentageRequestVolume = 50
+)
+
+// SuccessRateEjection configures the success-rate outlier detection
+// algorithm, which ejects hosts whose success rate is a configurable
+// number of standard deviations below the mean of the cluster.
+type SuccessRateEjection struct {
+	// StdevFactor is this value divided by 1000 and multiplied by the
+	// standard deviation to determine the ejection threshold. Defaults to
+	// 1900.
+	StdevFactor uint32 `json:"stdevFactor,omitempty"`
+	// EnforcementPercentage is the percentage chance that a host qualifying
+	// for ejection by this algorithm will actually be ejected. Defaults to
+	// 100.
+	EnforcementPercentage uint32 `json:"enforcementPercentage,omitempty"`
+	// MinimumHosts is the minimum number of hosts in the cluster required
+	// for this algorithm to run. Defaults to 5.
+	MinimumHosts uint32 `json:"minimumHosts,omitempty"`
+	// RequestVolume is the minimum number of requests an individual host
+	// must have received in the interval for it to be evaluated. Defaults
+	// to 100.
+	RequestVolume uint32 `json:"requestVolume,omitempty"`
+}
+
+// UnmarshalJSON seeds the fields with their documented defaults before
+// applying b, so that a partial success-rate config (e.g. just
+// "stdevFactor") still gets defaults for the fields it omits. LBConfig can't
+// do this for SuccessRateEjection the way it does for its own top-level
+// fields, since ParseConfig only sees the *SuccessRateEjection pointer
+// after json.Unmarshal has already zero-valued it.
+func (s *SuccessRateEjection) UnmarshalJSON(b []byte) error {
+	type alias SuccessRateEjection
+	a := alias{
+		StdevFactor:           defaultStdevFactor,
+		EnforcementPercentage: defaultEnforcementPercentage,
+		MinimumHosts:          defaultSuccessRateMinimumHosts,
+		RequestVolume:         defaultSuccessRateRequestVolume,
+	}
+	if err := json.Unmarshal(b, &a); err != nil {
+		return err
+	}
+	*s = SuccessRateEjection(a)
+	return nil
+}
+
+// FailurePercentageEjection configures the failure-percentage outlier
+// detection algorithm, which ejects hosts whose failure percentage exceeds
+// a fixed threshold.
+type FailurePercentageEjection struct {
+	// Threshold is the failure percentage (0-100) above which a host is a
+	// candidate for ejection. Defaults to 85.
+	Threshold uint32 `json:"threshold,omitempty"`
+	// EnforcementPercentage is the percentage chance that a host qualifying
+	// for ejection by this algorithm will actually be ejected. Defaults to
+	// 100.
+	EnforcementPercentage uint32 `json:"enforcementPercentage,omitempty"`
+	// MinimumHosts is the minimum number of hosts in the cluster required
+	// for this algorithm to run. Defaults to 5.
+	MinimumHosts uint32 `json:"minimumHosts,omitempty"`
+	// RequestVolume is the minimum number of requests an individual host
+	// must have received in the interval for it to be evaluated. Defaults
+	// to 50.
+	RequestVolume uint32 `json:"requestVolume,omitempty"`
+}
+
+// UnmarshalJSON seeds the fields with their documented defaults before
+// applying b; see SuccessRateEjection.UnmarshalJSON for why this can't be
+// done from LBConfig's own defaulting.
+func (f *FailurePercentageEjection) UnmarshalJSON(b []byte) error {
+	type alias FailurePercentageEjection
+	a := alias{
+		Threshold:             defaultFailurePercentageThreshold,
+		EnforcementPercentage: defaultEnforcementPercentage,
+		MinimumHosts:          defaultFailurePercentageMinimumHosts,
+		RequestVolume:         defaultFailurePercentageRequestVolume,
+	}
+	if err := json.Unmarshal(b, &a); err != nil {
+		return err
+	}
+	*f = FailurePercentageEjection(a)
+	return nil
+}
+
+// LBConfig is the service config schema for the outlier_detection policy,
+// matching the xDS OutlierDetection proto.
+type LBConfig struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+
+	// Interval is how often the ejection algorithms run. Defaults to 10s.
+	Interval internalDuration `json:"interval,omitempty"`
+	// BaseEjectionTime is the base duration an ejected host is excluded for;
+	// the actual duration scales with the number of times the host has been
+	// ejected, a count that is never reset.
+	// Defaults to 30s.
+	BaseEjectionTime internalDuration `json:"baseEjectionTime,omitempty"`
+	// MaxEjectionTime caps the duration computed from BaseEjectionTime.
+	// Defaults to 300s.
+	MaxEjectionTime internalDuration `json:"maxEjectionTime,omitempty"`
+	// MaxEjectionPercent is the maximum percentage (0-100) of hosts in the
+	// cluster that may be ejected at once. Defaults to 10.
+	MaxEjectionPercent uint32 `json:"maxEjectionPercent,omitempty"`
+
+	// SuccessRateEjection, if set, enables the success-rate algorithm.
+	SuccessRateEjection *SuccessRateEjection `json:"successRateEjection,omitempty"`
+	// FailurePercentageEjection, if set, enables the failure-percentage
+	// algorithm.
+	FailurePercentageEjection *FailurePercentageEjection `json:"failurePercentageEjection,omitempty"`
+
+	// ChildPolicy is the balancer config for the wrapped child, e.g.
+	// round_robin.
+	ChildPolicy *internalServiceConfig `json:"childPolicy,omitempty"`
+}
+
+func (bb) ParseConfig(j json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	cfg := &LBConfig{
+		Interval:           internalDuration{defaultInterval},
+		BaseEjectionTime:   internalDuration{defaultBaseEjectionTime},
+		MaxEjectionTime:    internalDuration{defaultMaxEjectionTime},
+		MaxEjectionPercent: defaultMaxEjectionPercent,
+	}
+	if err := json.Unmarshal(j, cfg); err != nil {
+		return nil, fmt.Errorf("outlierdetection: unable to unmarshal LBConfig: %v", err)
+	}
+	if cfg.ChildPolicy == nil {
+		return nil, fmt.Errorf("outlierdetection: no child policy configured")
+	}
+	return cfg, nil
+}
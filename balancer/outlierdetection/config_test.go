@@ -0,0 +1,90 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package outlierdetection
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/resolver"
+)
+
+func TestParseConfigSuccessRateEjectionDefaults(t *testing.T) {
+	j := []byte(`{"childPolicy": [{"round_robin":{}}], "successRateEjection": {}}`)
+	lbCfg, err := bb{}.ParseConfig(j)
+	if err != nil {
+		t.Fatalf("ParseConfig() failed: %v", err)
+	}
+	cfg := lbCfg.(*LBConfig)
+	sre := cfg.SuccessRateEjection
+	if sre == nil {
+		t.Fatalf("SuccessRateEjection = nil, want non-nil")
+	}
+	if sre.StdevFactor != defaultStdevFactor {
+		t.Errorf("StdevFactor = %v, want %v", sre.StdevFactor, defaultStdevFactor)
+	}
+	if sre.EnforcementPercentage != defaultEnforcementPercentage {
+		t.Errorf("EnforcementPercentage = %v, want %v", sre.EnforcementPercentage, defaultEnforcementPercentage)
+	}
+	if sre.MinimumHosts != defaultSuccessRateMinimumHosts {
+		t.Errorf("MinimumHosts = %v, want %v", sre.MinimumHosts, defaultSuccessRateMinimumHosts)
+	}
+	if sre.RequestVolume != defaultSuccessRateRequestVolume {
+		t.Errorf("RequestVolume = %v, want %v", sre.RequestVolume, defaultSuccessRateRequestVolume)
+	}
+}
+
+func TestParseConfigFailurePercentageEjectionPartial(t *testing.T) {
+	j := []byte(`{"childPolicy": [{"round_robin":{}}], "failurePercentageEjection": {"threshold": 50}}`)
+	lbCfg, err := bb{}.ParseConfig(j)
+	if err != nil {
+		t.Fatalf("ParseConfig() failed: %v", err)
+	}
+	cfg := lbCfg.(*LBConfig)
+	fpe := cfg.FailurePercentageEjection
+	if fpe == nil {
+		t.Fatalf("FailurePercentageEjection = nil, want non-nil")
+	}
+	if fpe.Threshold != 50 {
+		t.Errorf("Threshold = %v, want 50 (explicit value should not be overridden)", fpe.Threshold)
+	}
+	if fpe.EnforcementPercentage != defaultEnforcementPercentage {
+		t.Errorf("EnforcementPercentage = %v, want %v", fpe.EnforcementPercentage, defaultEnforcementPercentage)
+	}
+	if fpe.MinimumHosts != defaultFailurePercentageMinimumHosts {
+		t.Errorf("MinimumHosts = %v, want %v", fpe.MinimumHosts, defaultFailurePercentageMinimumHosts)
+	}
+	if fpe.RequestVolume != defaultFailurePercentageRequestVolume {
+		t.Errorf("RequestVolume = %v, want %v", fpe.RequestVolume, defaultFailurePercentageRequestVolume)
+	}
+
+	// A defaulted EnforcementPercentage must actually be capable of firing;
+	// before the fix, an omitted enforcementPercentage parsed as 0, and
+	// randPercent(0) is never true, so ejection silently never happened.
+	addrs := map[resolver.Address]*addressInfo{
+		{Addr: "good1"}: newAddrInfoWithResult(100, 0),
+		{Addr: "good2"}: newAddrInfoWithResult(100, 0),
+		{Addr: "good3"}: newAddrInfoWithResult(100, 0),
+		{Addr: "good4"}: newAddrInfoWithResult(100, 0),
+		{Addr: "bad"}:   newAddrInfoWithResult(10, 90),
+	}
+	applyFailurePercentageEjection(addrs, fpe)
+	if !addrs[resolver.Address{Addr: "bad"}].pendingEjection {
+		t.Errorf("pendingEjection = false, want true with defaulted EnforcementPercentage")
+	}
+}
@@ -0,0 +1,93 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package outlierdetection
+
+import (
+	"math"
+	"math/rand"
+
+	"google.golang.org/grpc/resolver"
+)
+
+// applySuccessRateEjection marks addresses whose success rate is more than
+// stdevFactor/1000 standard deviations below the mean success rate of the
+// cluster as pending ejection, provided the cluster has at least
+// MinimumHosts addresses and the address itself received at least
+// RequestVolume calls this interval.
+func applySuccessRateEjection(addrs map[resolver.Address]*addressInfo, cfg *SuccessRateEjection) {
+	if len(addrs) < int(cfg.MinimumHosts) {
+		return
+	}
+
+	var candidates []*addressInfo
+	var mean float64
+	for _, ai := range addrs {
+		if ai.requestVolume() < uint64(cfg.RequestVolume) {
+			continue
+		}
+		candidates = append(candidates, ai)
+		mean += ai.successRate()
+	}
+	if len(candidates) < int(cfg.MinimumHosts) {
+		return
+	}
+	mean /= float64(len(candidates))
+
+	var variance float64
+	for _, ai := range candidates {
+		d := ai.successRate() - mean
+		variance += d * d
+	}
+	variance /= float64(len(candidates))
+	stdev := math.Sqrt(variance)
+
+	threshold := mean - stdev*float64(cfg.StdevFactor)/1000
+	for _, ai := range candidates {
+		if ai.successRate() < threshold && randPercent(cfg.EnforcementPercentage) {
+			ai.pendingEjection = true
+		}
+	}
+}
+
+// applyFailurePercentageEjection marks addresses whose failure percentage
+// exceeds Threshold as pending ejection, provided the cluster has at least
+// MinimumHosts addresses and the address itself received at least
+// RequestVolume calls this interval.
+func applyFailurePercentageEjection(addrs map[resolver.Address]*addressInfo, cfg *FailurePercentageEjection) {
+	if len(addrs) < int(cfg.MinimumHosts) {
+		return
+	}
+	for _, ai := range addrs {
+		if ai.requestVolume() < uint64(cfg.RequestVolume) {
+			continue
+		}
+		if ai.failurePercentage() > float64(cfg.Threshold) && randPercent(cfg.EnforcementPercentage) {
+			ai.pendingEjection = true
+		}
+	}
+}
+
+// randPercent reports whether a random draw falls within the first pct
+// percent, used to apply an algorithm's EnforcementPercentage.
+func randPercent(pct uint32) bool {
+	if pct >= 100 {
+		return true
+	}
+	return uint32(rand.Intn(100)) < pct
+}
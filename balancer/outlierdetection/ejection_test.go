@@ -0,0 +1,76 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package outlierdetection
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/resolver"
+)
+
+func newAddrInfoWithResult(success, failure uint64) *addressInfo {
+	ai := &addressInfo{}
+	ai.callCounter.inactive = bucket{success: success, failure: failure}
+	return ai
+}
+
+func TestApplyFailurePercentageEjection(t *testing.T) {
+	addrs := map[resolver.Address]*addressInfo{
+		{Addr: "good1"}: newAddrInfoWithResult(100, 0),
+		{Addr: "good2"}: newAddrInfoWithResult(100, 0),
+		{Addr: "good3"}: newAddrInfoWithResult(100, 0),
+		{Addr: "good4"}: newAddrInfoWithResult(100, 0),
+		{Addr: "bad"}:   newAddrInfoWithResult(10, 90),
+	}
+	cfg := &FailurePercentageEjection{
+		Threshold:             50,
+		EnforcementPercentage: 100,
+		MinimumHosts:          5,
+		RequestVolume:         50,
+	}
+	applyFailurePercentageEjection(addrs, cfg)
+
+	for addr, ai := range addrs {
+		want := addr.Addr == "bad"
+		if ai.pendingEjection != want {
+			t.Errorf("addr %v: pendingEjection = %v, want %v", addr.Addr, ai.pendingEjection, want)
+		}
+	}
+}
+
+func TestApplyFailurePercentageEjectionBelowMinimumHosts(t *testing.T) {
+	addrs := map[resolver.Address]*addressInfo{
+		{Addr: "bad"}: newAddrInfoWithResult(10, 90),
+	}
+	cfg := &FailurePercentageEjection{Threshold: 50, EnforcementPercentage: 100, MinimumHosts: 5, RequestVolume: 50}
+	applyFailurePercentageEjection(addrs, cfg)
+	if addrs[resolver.Address{Addr: "bad"}].pendingEjection {
+		t.Errorf("pendingEjection = true, want false when cluster is below MinimumHosts")
+	}
+}
+
+func TestSuccessRateAndFailurePercentage(t *testing.T) {
+	ai := newAddrInfoWithResult(75, 25)
+	if got, want := ai.successRate(), 0.75; got != want {
+		t.Errorf("successRate() = %v, want %v", got, want)
+	}
+	if got, want := ai.failurePercentage(), 25.0; got != want {
+		t.Errorf("failurePercentage() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,497 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package weightedroundrobin implements a client-side load balancing policy
+// that distributes RPCs across a set of endpoints in proportion to
+// utilization/QPS weights reported by each endpoint via ORCA. Endpoints that
+// have not yet reported a load get the mean weight of those that have, so
+// picking is plain round robin until some endpoint reports.
+package weightedroundrobin
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/orca"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+// Name is the name of the weighted_round_robin balancer policy.
+const Name = "weighted_round_robin"
+
+var logger = grpclog.Component("weighted-round-robin")
+
+func init() {
+	balancer.Register(bb{})
+}
+
+// LBConfig is the service config schema for the weighted_round_robin
+// policy, matching the xDS WeightedRoundRobin LB config.
+type LBConfig struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+
+	// BlackoutPeriod is how long to wait after a SubConn becomes READY
+	// before trusting its reported weight; until then it uses the average
+	// weight of the other endpoints. Defaults to 10s.
+	BlackoutPeriod time.Duration `json:"blackoutPeriod,omitempty"`
+	// WeightUpdatePeriod is how often the picker is rebuilt from the latest
+	// ORCA reports. Defaults to 1s.
+	WeightUpdatePeriod time.Duration `json:"weightUpdatePeriod,omitempty"`
+	// ErrorUtilizationPenalty scales the fraction of failed requests into an
+	// extra utilization penalty applied when computing weights. Defaults to
+	// 1.0.
+	ErrorUtilizationPenalty float64 `json:"errorUtilizationPenalty,omitempty"`
+}
+
+const (
+	defaultBlackoutPeriod          = 10 * time.Second
+	defaultWeightUpdatePeriod      = time.Second
+	defaultErrorUtilizationPenalty = 1.0
+)
+
+type bb struct{}
+
+func (bb) Name() string { return Name }
+
+func (bb) Build(cc balancer.ClientConn, bOpts balancer.BuildOptions) balancer.Balancer {
+	b := &wrrBalancer{
+		cc:       cc,
+		subConns: make(map[balancer.SubConn]*weightedSubConn),
+		done:     make(chan struct{}),
+	}
+	pb := &pickerBuilder{b: b}
+	b.child = base.NewBalancerBuilder(Name, pb, base.Config{HealthCheck: true}).Build(&wrrClientConn{ClientConn: cc, b: b}, bOpts)
+	b.scheduleRefresh(defaultWeightUpdatePeriod)
+	return b
+}
+
+func (bb) ParseConfig(j json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	// Durations use the service config's string encoding, e.g. "10s".
+	var raw struct {
+		BlackoutPeriod, WeightUpdatePeriod string
+		ErrorUtilizationPenalty            float64
+	}
+	if err := json.Unmarshal(j, &raw); err != nil {
+		return nil, fmt.Errorf("wrr: unable to unmarshal LBConfig: %v", err)
+	}
+	cfg := &LBConfig{ErrorUtilizationPenalty: raw.ErrorUtilizationPenalty}
+	var err error
+	if raw.BlackoutPeriod != "" {
+		if cfg.BlackoutPeriod, err = time.ParseDuration(raw.BlackoutPeriod); err != nil {
+			return nil, fmt.Errorf("wrr: invalid blackoutPeriod: %v", err)
+		}
+	}
+	if raw.WeightUpdatePeriod != "" {
+		if cfg.WeightUpdatePeriod, err = time.ParseDuration(raw.WeightUpdatePeriod); err != nil {
+			return nil, fmt.Errorf("wrr: invalid weightUpdatePeriod: %v", err)
+		}
+	}
+	if cfg.BlackoutPeriod <= 0 {
+		cfg.BlackoutPeriod = defaultBlackoutPeriod
+	}
+	if cfg.WeightUpdatePeriod <= 0 {
+		cfg.WeightUpdatePeriod = defaultWeightUpdatePeriod
+	}
+	if cfg.ErrorUtilizationPenalty == 0 {
+		cfg.ErrorUtilizationPenalty = defaultErrorUtilizationPenalty
+	}
+	return cfg, nil
+}
+
+// wrrBalancer delegates connectivity management to a base balancer (which
+// creates one SubConn per address) and layers weight tracking, fed by ORCA
+// out-of-band reports, on top of it to build weighted pickers. A ticker,
+// paced by LBConfig.WeightUpdatePeriod, periodically rebuilds and pushes a
+// fresh picker so that ORCA reports received between connectivity events
+// are actually reflected in picking, per the xDS WRR spec.
+type wrrBalancer struct {
+	cc    balancer.ClientConn
+	child balancer.Balancer
+
+	mu        sync.Mutex
+	cfg       *LBConfig
+	subConns  map[balancer.SubConn]*weightedSubConn
+	lastInfo  base.PickerBuildInfo
+	lastState balancer.State
+	haveState bool
+
+	timerMu sync.Mutex
+	timer   *time.Timer
+	done    chan struct{}
+}
+
+func (b *wrrBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	cfg, _ := s.BalancerConfig.(*LBConfig)
+	if cfg == nil {
+		cfg = &LBConfig{
+			BlackoutPeriod:          defaultBlackoutPeriod,
+			WeightUpdatePeriod:      defaultWeightUpdatePeriod,
+			ErrorUtilizationPenalty: defaultErrorUtilizationPenalty,
+		}
+	}
+	b.mu.Lock()
+	b.cfg = cfg
+	b.mu.Unlock()
+	return b.child.UpdateClientConnState(s)
+}
+
+func (b *wrrBalancer) ResolverError(err error) { b.child.ResolverError(err) }
+
+func (b *wrrBalancer) UpdateSubConnState(sc balancer.SubConn, s balancer.SubConnState) {
+	if s.ConnectivityState == connectivity.Ready {
+		b.getWeightedSubConn(sc).setReadyAt()
+	}
+	b.child.UpdateSubConnState(sc, s)
+}
+
+func (b *wrrBalancer) Close() {
+	b.timerMu.Lock()
+	select {
+	case <-b.done:
+	default:
+		close(b.done)
+	}
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	b.timerMu.Unlock()
+	b.child.Close()
+
+	b.mu.Lock()
+	subConns := b.subConns
+	b.subConns = make(map[balancer.SubConn]*weightedSubConn)
+	b.mu.Unlock()
+	for _, wsc := range subConns {
+		if wsc.stopListener != nil {
+			wsc.stopListener()
+		}
+	}
+}
+
+// scheduleRefresh arranges for refreshPicker to run once after d, and to
+// reschedule itself using the then-current LBConfig.WeightUpdatePeriod,
+// until Close stops it. It is started once, at Build time, and keeps
+// rescheduling itself rather than being restarted on every config update,
+// so a single timer goroutine lives for the balancer's lifetime.
+func (b *wrrBalancer) scheduleRefresh(d time.Duration) {
+	b.timerMu.Lock()
+	defer b.timerMu.Unlock()
+	select {
+	case <-b.done:
+		return
+	default:
+	}
+	b.timer = time.AfterFunc(d, b.tick)
+}
+
+func (b *wrrBalancer) tick() {
+	select {
+	case <-b.done:
+		return
+	default:
+	}
+	b.refreshPicker()
+
+	b.mu.Lock()
+	cfg := b.cfg
+	b.mu.Unlock()
+	period := defaultWeightUpdatePeriod
+	if cfg != nil && cfg.WeightUpdatePeriod > 0 {
+		period = cfg.WeightUpdatePeriod
+	}
+	b.scheduleRefresh(period)
+}
+
+// refreshPicker rebuilds the picker from the most recently seen set of
+// READY SubConns (with their now-current weights) and pushes it to cc,
+// without waiting for the child balancer to report a new connectivity
+// transition.
+func (b *wrrBalancer) refreshPicker() {
+	b.mu.Lock()
+	if !b.haveState {
+		b.mu.Unlock()
+		return
+	}
+	info := b.lastInfo
+	state := b.lastState
+	b.mu.Unlock()
+
+	state.Picker = b.buildPicker(info)
+	b.cc.UpdateState(state)
+}
+
+// weightedSubConn tracks the most recently reported ORCA utilization/QPS for
+// a single SubConn, along with when it became READY (for BlackoutPeriod) and
+// a running count of RPC outcomes observed through the picker's Done
+// callback (for ErrorUtilizationPenalty).
+type weightedSubConn struct {
+	sc balancer.SubConn
+
+	mu           sync.Mutex
+	readyAt      time.Time
+	cpuUtil      float64
+	rps          float64
+	stopListener func()
+
+	successes uint64 // atomic
+	failures  uint64 // atomic
+}
+
+// setReadyAt records the current time as when w's SubConn most recently
+// became READY, restarting BlackoutPeriod on every reconnect rather than
+// only at SubConn creation.
+func (w *weightedSubConn) setReadyAt() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.readyAt = time.Now()
+}
+
+func (w *weightedSubConn) sinceReady() time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return time.Since(w.readyAt)
+}
+
+func (w *weightedSubConn) OnLoadReport(lr *orca.LoadReport) {
+	if lr == nil || lr.CPUUtilization <= 0 || lr.RPSFractional <= 0 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.cpuUtil = lr.CPUUtilization
+	w.rps = lr.RPSFractional
+}
+
+// recordResult is invoked as the picker's Done callback for every RPC
+// completed on w's SubConn, and feeds ErrorUtilizationPenalty via weight.
+func (w *weightedSubConn) recordResult(err error) {
+	if err != nil {
+		atomic.AddUint64(&w.failures, 1)
+		return
+	}
+	atomic.AddUint64(&w.successes, 1)
+}
+
+// weight derives a picking weight from the most recent ORCA report as
+// qps / (cpu_utilization + errorUtilizationPenalty * error_rate), matching
+// the xDS weighted_round_robin specification's default formula, where
+// error_rate is failures / (successes + failures) over the lifetime of the
+// SubConn: successes and failures are plain counters that are never reset,
+// so this is a cumulative rate, not a windowed or recent one. ok is false
+// if no usable ORCA report has been received yet.
+func (w *weightedSubConn) weight(errorUtilizationPenalty float64) (weight float64, ok bool) {
+	w.mu.Lock()
+	cpu, rps := w.cpuUtil, w.rps
+	w.mu.Unlock()
+	if cpu <= 0 || rps <= 0 {
+		return 0, false
+	}
+	successes := atomic.LoadUint64(&w.successes)
+	failures := atomic.LoadUint64(&w.failures)
+	var errorRate float64
+	if total := successes + failures; total > 0 {
+		errorRate = float64(failures) / float64(total)
+	}
+	denom := cpu + errorUtilizationPenalty*errorRate
+	if denom <= 0 {
+		return 0, false
+	}
+	return rps / denom, true
+}
+
+// pickerBuilder builds pickers that hand out SubConns with probability
+// proportional to their currently known weight, using the classic smooth
+// weighted round robin algorithm; SubConns without a usable weight yet (new,
+// still in BlackoutPeriod, or never reported) get the mean weight of their
+// peers so a slow-to-report endpoint is not starved or overloaded.
+type pickerBuilder struct {
+	b *wrrBalancer
+}
+
+func (pb *pickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	return pb.b.buildPicker(info)
+}
+
+// buildPicker computes a weightedPicker from info, caching info so that
+// refreshPicker can rebuild on a timer without a new call from the child
+// balancer.
+func (b *wrrBalancer) buildPicker(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	b.mu.Lock()
+	b.lastInfo = info
+	cfg := b.cfg
+	b.mu.Unlock()
+
+	penalty := defaultErrorUtilizationPenalty
+	if cfg != nil && cfg.ErrorUtilizationPenalty > 0 {
+		penalty = cfg.ErrorUtilizationPenalty
+	}
+
+	entries := make([]*weightedPickerEntry, 0, len(info.ReadySCs))
+	var total float64
+	var known int
+	for sc := range info.ReadySCs {
+		wsc := b.getWeightedSubConn(sc)
+		w, ok := wsc.weight(penalty)
+		blackout := cfg != nil && wsc.sinceReady() < cfg.BlackoutPeriod
+		if ok && w > 0 && !blackout {
+			total += w
+			known++
+		}
+		entries = append(entries, &weightedPickerEntry{sc: sc, wsc: wsc})
+	}
+	mean := 1.0
+	if known > 0 {
+		mean = total / float64(known)
+	}
+	for _, e := range entries {
+		w, ok := e.wsc.weight(penalty)
+		blackout := cfg != nil && e.wsc.sinceReady() < cfg.BlackoutPeriod
+		if !ok || w <= 0 || blackout {
+			w = mean
+		}
+		e.weight = w
+	}
+	return &weightedPicker{entries: entries}
+}
+
+// getWeightedSubConn returns the weightedSubConn registered for sc by
+// wrrClientConn.NewSubConn. It should always already exist; the fallback
+// path only guards against a child balancer that hands back an sc this
+// balancer never created.
+func (b *wrrBalancer) getWeightedSubConn(sc balancer.SubConn) *weightedSubConn {
+	b.mu.Lock()
+	wsc, ok := b.subConns[sc]
+	b.mu.Unlock()
+	if ok {
+		return wsc
+	}
+	return b.addSubConn(sc)
+}
+
+func (b *wrrBalancer) addSubConn(sc balancer.SubConn) *weightedSubConn {
+	b.mu.Lock()
+	if wsc, ok := b.subConns[sc]; ok {
+		b.mu.Unlock()
+		return wsc
+	}
+	wsc := &weightedSubConn{sc: sc, readyAt: time.Now()}
+	b.subConns[sc] = wsc
+	b.mu.Unlock()
+	wsc.stopListener = orca.RegisterOOBListener(sc, wsc, orca.OOBListenerOptions{ReportInterval: time.Second})
+	return wsc
+}
+
+// removeSubConn stops the OOB listener for sc, if any, and forgets it.
+// Called by wrrClientConn.RemoveSubConn when the child balancer tears down
+// a SubConn, e.g. because its address was removed by the resolver.
+func (b *wrrBalancer) removeSubConn(sc balancer.SubConn) {
+	b.mu.Lock()
+	wsc, ok := b.subConns[sc]
+	if ok {
+		delete(b.subConns, sc)
+	}
+	b.mu.Unlock()
+	if ok && wsc.stopListener != nil {
+		wsc.stopListener()
+	}
+}
+
+// wrrClientConn wraps the ClientConn given to the child base.Balancer so
+// this balancer can track each SubConn's lifetime (to start/stop ORCA OOB
+// listening) and capture the aggregated connectivity state the child last
+// reported (so refreshPicker can reuse it when only the picker, not the
+// overall state, needs to change).
+type wrrClientConn struct {
+	balancer.ClientConn
+	b *wrrBalancer
+}
+
+func (w *wrrClientConn) NewSubConn(addrs []resolver.Address, opts balancer.NewSubConnOptions) (balancer.SubConn, error) {
+	sc, err := w.ClientConn.NewSubConn(addrs, opts)
+	if err != nil {
+		return nil, err
+	}
+	w.b.addSubConn(sc)
+	return sc, nil
+}
+
+func (w *wrrClientConn) RemoveSubConn(sc balancer.SubConn) {
+	w.b.removeSubConn(sc)
+	w.ClientConn.RemoveSubConn(sc)
+}
+
+func (w *wrrClientConn) UpdateState(s balancer.State) {
+	w.b.mu.Lock()
+	w.b.lastState = s
+	w.b.haveState = true
+	w.b.mu.Unlock()
+	w.ClientConn.UpdateState(s)
+}
+
+type weightedPickerEntry struct {
+	sc     balancer.SubConn
+	wsc    *weightedSubConn
+	weight float64
+
+	current float64
+}
+
+// weightedPicker implements the smooth weighted round robin algorithm: on
+// each pick, every entry's current counter is incremented by its weight,
+// the entry with the highest current counter is chosen, and that entry's
+// counter is reduced by the sum of all weights.
+type weightedPicker struct {
+	mu      sync.Mutex
+	entries []*weightedPickerEntry
+}
+
+func (p *weightedPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var total float64
+	var best *weightedPickerEntry
+	for _, e := range p.entries {
+		e.current += e.weight
+		total += e.weight
+		if best == nil || e.current > best.current {
+			best = e
+		}
+	}
+	if best == nil {
+		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+	}
+	best.current -= total
+	return balancer.PickResult{
+		SubConn: best.sc,
+		Done: func(info balancer.DoneInfo) {
+			best.wsc.recordResult(info.Err)
+		},
+	}, nil
+}
@@ -0,0 +1,81 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package weightedroundrobin
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/orca"
+)
+
+func TestParseConfigDefaults(t *testing.T) {
+	cfg, err := bb{}.ParseConfig([]byte(`{}`))
+	if err != nil {
+		t.Fatalf("ParseConfig() failed: %v", err)
+	}
+	got := cfg.(*LBConfig)
+	if got.BlackoutPeriod != defaultBlackoutPeriod {
+		t.Errorf("BlackoutPeriod = %v, want %v", got.BlackoutPeriod, defaultBlackoutPeriod)
+	}
+	if got.WeightUpdatePeriod != defaultWeightUpdatePeriod {
+		t.Errorf("WeightUpdatePeriod = %v, want %v", got.WeightUpdatePeriod, defaultWeightUpdatePeriod)
+	}
+	if got.ErrorUtilizationPenalty != defaultErrorUtilizationPenalty {
+		t.Errorf("ErrorUtilizationPenalty = %v, want %v", got.ErrorUtilizationPenalty, defaultErrorUtilizationPenalty)
+	}
+}
+
+func TestParseConfigOverrides(t *testing.T) {
+	cfg, err := bb{}.ParseConfig([]byte(`{"blackoutPeriod": "5s", "weightUpdatePeriod": "2s", "errorUtilizationPenalty": 2.5}`))
+	if err != nil {
+		t.Fatalf("ParseConfig() failed: %v", err)
+	}
+	got := cfg.(*LBConfig)
+	if got.BlackoutPeriod != 5*time.Second {
+		t.Errorf("BlackoutPeriod = %v, want 5s", got.BlackoutPeriod)
+	}
+	if got.ErrorUtilizationPenalty != 2.5 {
+		t.Errorf("ErrorUtilizationPenalty = %v, want 2.5", got.ErrorUtilizationPenalty)
+	}
+}
+
+func TestWeightedSubConnWeight(t *testing.T) {
+	wsc := &weightedSubConn{}
+	if _, ok := wsc.weight(defaultErrorUtilizationPenalty); ok {
+		t.Errorf("weight() before any load report: ok = true, want false")
+	}
+
+	wsc.OnLoadReport(&orca.LoadReport{CPUUtilization: 0.5, RPSFractional: 100})
+	if w, ok := wsc.weight(defaultErrorUtilizationPenalty); !ok || w != 200 {
+		t.Errorf("weight() = (%v, %v), want (200, true)", w, ok)
+	}
+
+	// A 50% failure rate with the default penalty of 1.0 should double the
+	// utilization denominator, halving the weight relative to CPU alone.
+	for i := 0; i < 5; i++ {
+		wsc.recordResult(nil)
+		wsc.recordResult(fmt.Errorf("rpc failed"))
+	}
+	want := 100 / (0.5 + 1.0*0.5)
+	if w, ok := wsc.weight(defaultErrorUtilizationPenalty); !ok || w != want {
+		t.Errorf("weight() with 50%% errors = (%v, %v), want (%v, true)", w, ok, want)
+	}
+}
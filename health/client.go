@@ -0,0 +1,114 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package health provides gRPC's client-side and server-side health
+// checking support, as defined by the standard grpc.health.v1.Health
+// service.
+package health
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/internal"
+	"google.golang.org/grpc/internal/backoff"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func init() {
+	internal.HealthCheckFunc = clientHealthCheck
+}
+
+// clientHealthCheck is the default internal.HealthChecker, installed by
+// this package's init() so that importing google.golang.org/grpc/health is
+// enough to enable service-config-driven client-side health checking. It
+// opens a grpc.health.v1.Health/Watch stream for serviceName over the
+// connection produced by newStream, and calls reportHealth(true, nil) on
+// SERVING and reportHealth(false, nil) on NOT_SERVING or SERVICE_UNKNOWN. A
+// stream ending, whether by error or by a clean EOF (e.g. the server
+// closing the Watch stream on its own), is treated as unhealthy and
+// retried with exponential backoff until ctx is canceled. The backoff is
+// reset when a stream has delivered at least one health report and then
+// ended cleanly (EOF); a stream that ends with an error advances it. So a
+// connection that has been healthy for a while and then merely rotates its
+// stream doesn't inherit a saturated backoff from earlier, unrelated
+// reconnect attempts.
+func clientHealthCheck(ctx context.Context, newStream func(string) (interface{}, error), reportHealth func(bool, error), serviceName string) error {
+	bs := backoff.DefaultExponential
+	retries := 0
+	for ctx.Err() == nil {
+		streamed, err := runHealthCheckStream(ctx, newStream, reportHealth, serviceName)
+		reportHealth(false, err)
+		if err == nil && streamed {
+			retries = 0
+		} else {
+			retries++
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(bs.Backoff(retries)):
+		}
+	}
+	return ctx.Err()
+}
+
+// runHealthCheckStream drives a single Watch stream to completion, reporting
+// health as responses arrive. streamed reports whether at least one health
+// report was received before the stream ended, so the caller can tell a
+// productive connection (worth resetting the backoff for) from a stream
+// that failed or ended before ever delivering anything.
+func runHealthCheckStream(ctx context.Context, newStream func(string) (interface{}, error), reportHealth func(bool, error), serviceName string) (streamed bool, err error) {
+	rawStream, err := newStream("/grpc.health.v1.Health/Watch")
+	if err != nil {
+		return false, err
+	}
+	stream, ok := rawStream.(grpc.ClientStream)
+	if !ok {
+		return false, fmt.Errorf("health: stream does not implement grpc.ClientStream")
+	}
+	if err := stream.SendMsg(&healthpb.HealthCheckRequest{Service: serviceName}); err != nil {
+		return false, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return false, err
+	}
+	for {
+		resp := new(healthpb.HealthCheckResponse)
+		if err := stream.RecvMsg(resp); err != nil {
+			if err == io.EOF {
+				return streamed, nil
+			}
+			return streamed, err
+		}
+		streamed = true
+		switch resp.Status {
+		case healthpb.HealthCheckResponse_SERVING:
+			reportHealth(true, nil)
+		case healthpb.HealthCheckResponse_NOT_SERVING, healthpb.HealthCheckResponse_SERVICE_UNKNOWN:
+			reportHealth(false, nil)
+		}
+		if ctx.Err() != nil {
+			return streamed, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,90 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package health
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// fakeHealthStream implements enough of grpc.ClientStream to drive
+// runHealthCheckStream through a scripted sequence of responses.
+type fakeHealthStream struct {
+	grpc.ClientStream
+	responses []*healthpb.HealthCheckResponse
+	i         int
+}
+
+func (f *fakeHealthStream) SendMsg(m interface{}) error { return nil }
+func (f *fakeHealthStream) CloseSend() error             { return nil }
+func (f *fakeHealthStream) RecvMsg(m interface{}) error {
+	if f.i >= len(f.responses) {
+		return io.EOF
+	}
+	*(m.(*healthpb.HealthCheckResponse)) = *f.responses[f.i]
+	f.i++
+	return nil
+}
+
+func TestRunHealthCheckStream(t *testing.T) {
+	fs := &fakeHealthStream{responses: []*healthpb.HealthCheckResponse{
+		{Status: healthpb.HealthCheckResponse_SERVING},
+		{Status: healthpb.HealthCheckResponse_NOT_SERVING},
+		{Status: healthpb.HealthCheckResponse_SERVING},
+	}}
+	newStream := func(string) (interface{}, error) { return fs, nil }
+
+	var got []bool
+	reportHealth := func(healthy bool, err error) { got = append(got, healthy) }
+
+	streamed, err := runHealthCheckStream(context.Background(), newStream, reportHealth, "myservice")
+	if err != nil {
+		t.Fatalf("runHealthCheckStream() = %v, want nil", err)
+	}
+	if !streamed {
+		t.Errorf("runHealthCheckStream() streamed = false, want true")
+	}
+
+	want := []bool{true, false, true}
+	if len(got) != len(want) {
+		t.Fatalf("reported health = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("reported health[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestRunHealthCheckStreamEmptyStreamNotStreamed(t *testing.T) {
+	fs := &fakeHealthStream{}
+	newStream := func(string) (interface{}, error) { return fs, nil }
+	streamed, err := runHealthCheckStream(context.Background(), newStream, func(bool, error) {}, "myservice")
+	if err != nil {
+		t.Fatalf("runHealthCheckStream() = %v, want nil", err)
+	}
+	if streamed {
+		t.Errorf("runHealthCheckStream() streamed = true, want false for a stream that never delivered a report")
+	}
+}
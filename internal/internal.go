@@ -0,0 +1,49 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package internal contains gRPC-internal code that must be shared amongst
+// multiple gRPC packages but must not be used outside of gRPC. Hooks that
+// need to be set by one package and consumed by another, without those
+// packages otherwise depending on each other, live here.
+package internal
+
+import "context"
+
+// HealthCheckFunc is the function used to perform client-side,
+// connection-level health checking. It defaults to nil, meaning no health
+// checking is performed unless something registers an implementation here
+// (the google.golang.org/grpc/health package does so in its init(), so
+// importing it for side effects is what enables health checking;
+// google.golang.org/grpc/health/grpc_health_v1 alone only provides the
+// generated client/server types and registers no HealthCheckFunc).
+// Applications with a non-standard health-checking protocol may install
+// their own HealthChecker here before creating any ClientConns.
+var HealthCheckFunc HealthChecker
+
+// HealthChecker checks the health of the connection behind newStream for
+// serviceName, invoking reportHealth every time the observed health status
+// changes. newStream opens a new client stream on the method it is passed;
+// its return value is expected to satisfy grpc.ClientStream. HealthChecker
+// blocks, retrying transient stream failures with backoff, until ctx is
+// canceled.
+type HealthChecker func(
+	ctx context.Context,
+	newStream func(method string) (interface{}, error),
+	reportHealth func(healthy bool, err error),
+	serviceName string,
+) error
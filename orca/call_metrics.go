@@ -0,0 +1,157 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package orca
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/metadata"
+)
+
+var logger = grpclog.Component("orca")
+
+// recorder is the default, concurrency-safe implementation of
+// CallMetricRecorder.
+type recorder struct {
+	mu sync.Mutex
+	lr LoadReport
+}
+
+func newRecorder() *recorder {
+	return &recorder{lr: LoadReport{
+		RequestCost:  make(map[string]float64),
+		Utilization:  make(map[string]float64),
+		NamedMetrics: make(map[string]float64),
+	}}
+}
+
+func (r *recorder) SetCPUUtilization(val float64) CallMetricRecorder {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lr.CPUUtilization = val
+	return r
+}
+
+func (r *recorder) SetMemoryUtilization(val float64) CallMetricRecorder {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lr.MemUtilization = val
+	return r
+}
+
+func (r *recorder) SetUtilization(name string, val float64) CallMetricRecorder {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lr.Utilization[name] = val
+	return r
+}
+
+func (r *recorder) DeleteUtilization(name string) CallMetricRecorder {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.lr.Utilization, name)
+	return r
+}
+
+func (r *recorder) SetRequestCost(name string, val float64) CallMetricRecorder {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lr.RequestCost[name] = val
+	return r
+}
+
+func (r *recorder) DeleteRequestCost(name string) CallMetricRecorder {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.lr.RequestCost, name)
+	return r
+}
+
+func (r *recorder) toLoadReport() *LoadReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lr := r.lr
+	lr.RequestCost = copyMap(r.lr.RequestCost)
+	lr.Utilization = copyMap(r.lr.Utilization)
+	lr.NamedMetrics = copyMap(r.lr.NamedMetrics)
+	return &lr
+}
+
+func copyMap(m map[string]float64) map[string]float64 {
+	if len(m) == 0 {
+		return nil
+	}
+	out := make(map[string]float64, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+// CallMetricsServerOption returns the grpc.ServerOptions that install unary
+// and streaming interceptors which make a CallMetricRecorder available via
+// CallMetricRecorderFromContext in every RPC handled by the server, and
+// attach the recorded metrics to the RPC's trailing metadata as an ORCA
+// load report once the handler (or stream) returns. A single grpc.ServerOption
+// cannot bundle both a unary and a streaming interceptor, so both are
+// returned together for the caller to pass to grpc.NewServer.
+func CallMetricsServerOption() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unaryInterceptor),
+		grpc.ChainStreamInterceptor(streamInterceptor),
+	}
+}
+
+func unaryInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	rec := newRecorder()
+	resp, err := handler(newContextWithRecorder(ctx, rec), req)
+	attachTrailer(ctx, rec)
+	return resp, err
+}
+
+func streamInterceptor(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	rec := newRecorder()
+	err := handler(srv, &recorderServerStream{ServerStream: ss, ctx: newContextWithRecorder(ss.Context(), rec)})
+	attachTrailer(ss.Context(), rec)
+	return err
+}
+
+// recorderServerStream wraps a grpc.ServerStream to substitute a Context
+// that carries a CallMetricRecorder, the same way unaryInterceptor does for
+// unary handlers.
+type recorderServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *recorderServerStream) Context() context.Context { return s.ctx }
+
+func attachTrailer(ctx context.Context, rec *recorder) {
+	b, err := rec.toLoadReport().Marshal()
+	if err != nil {
+		logger.Warningf("failed to marshal ORCA load report: %v", err)
+		return
+	}
+	if err := grpc.SetTrailer(ctx, metadata.Pairs(TrailerMetadataKey, string(b))); err != nil {
+		logger.Warningf("failed to set ORCA load report trailer: %v", err)
+	}
+}
@@ -0,0 +1,284 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package orca
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// LoadReport is the Go representation of the xds.data.orca.v3.OrcaLoadReport
+// proto message. It carries server-side cost and utilization signals from a
+// single RPC (when sent in trailing metadata) or as a periodic snapshot
+// (when sent over the out-of-band streaming service).
+//
+// Marshal/Unmarshal below hand-encode the subset of the wire format this
+// package needs (six scalar/map field numbers, no packed repeated fields)
+// rather than depending on a generated xds.data.orca.v3 package, since the
+// upstream .proto sources and a protoc toolchain aren't available in this
+// tree. This is a stand-in, and an incomplete one: besides omitting packed
+// repeated fields, Unmarshal gives up on the rest of the message as soon as
+// it hits a wire type it doesn't recognize (namely a deprecated group
+// start/end marker), rather than skipping just that one field the way a
+// generated proto.Message's Unmarshal would. LoadReport should be replaced
+// by a real generated type, with Marshal/Unmarshal deferring to the
+// standard proto.Marshal/Unmarshal, once that dependency can be vendored in.
+type LoadReport struct {
+	// CPUUtilization is the CPU utilization, normalized to [0, 1] where 1 is
+	// 100% of usable CPU.
+	CPUUtilization float64
+	// MemUtilization is the memory utilization, normalized to [0, 1].
+	MemUtilization float64
+	// RPSFractional is the instantaneous requests-per-second being served.
+	RPSFractional float64
+	// RequestCost holds the cost of serving the current request, keyed by an
+	// application-defined metric name.
+	RequestCost map[string]float64
+	// Utilization holds generic, application-defined utilization metrics,
+	// keyed by metric name.
+	Utilization map[string]float64
+	// NamedMetrics holds additional named metrics not covered by the
+	// well-known fields above. It exists alongside Utilization to allow
+	// servers to report both coarse-grained (Utilization) and fine-grained
+	// (NamedMetrics) signals in the same report.
+	NamedMetrics map[string]float64
+}
+
+// proto field numbers for xds.data.orca.v3.OrcaLoadReport.
+const (
+	fieldCPUUtilization = 1
+	fieldMemUtilization = 2
+	fieldRequestCost    = 4
+	fieldUtilization    = 5
+	fieldRPSFractional  = 6
+	fieldNamedMetrics   = 7
+)
+
+const (
+	wireTypeVarint  = 0
+	wireTypeFixed64 = 1
+	wireTypeBytes   = 2
+	wireTypeFixed32 = 5
+)
+
+// Reset, String, and ProtoMessage implement proto.Message, so that
+// *LoadReport can be passed directly to stream.SendMsg/RecvMsg and
+// marshaled/unmarshaled by gRPC's default codec, which type-asserts message
+// bodies to proto.Message before checking for the Marshal/Unmarshal fast
+// path implemented below.
+func (lr *LoadReport) Reset()         { *lr = LoadReport{} }
+func (lr *LoadReport) String() string { return fmt.Sprintf("%+v", *lr) }
+func (lr *LoadReport) ProtoMessage()  {}
+
+// Marshal encodes the LoadReport using the protobuf wire format of
+// xds.data.orca.v3.OrcaLoadReport.
+func (lr *LoadReport) Marshal() ([]byte, error) {
+	var b []byte
+	b = appendFixed64Field(b, fieldCPUUtilization, lr.CPUUtilization)
+	b = appendFixed64Field(b, fieldMemUtilization, lr.MemUtilization)
+	b = appendFixed64Field(b, fieldRPSFractional, lr.RPSFractional)
+	b = appendMapField(b, fieldRequestCost, lr.RequestCost)
+	b = appendMapField(b, fieldUtilization, lr.Utilization)
+	b = appendMapField(b, fieldNamedMetrics, lr.NamedMetrics)
+	return b, nil
+}
+
+// Unmarshal decodes b, the wire format of xds.data.orca.v3.OrcaLoadReport,
+// into lr.
+func (lr *LoadReport) Unmarshal(b []byte) error {
+	for len(b) > 0 {
+		num, wt, n, err := consumeTag(b)
+		if err != nil {
+			return err
+		}
+		b = b[n:]
+		switch wt {
+		case wireTypeVarint:
+			_, n, err := consumeVarint(b)
+			if err != nil {
+				return err
+			}
+			b = b[n:]
+		case wireTypeFixed64:
+			if len(b) < 8 {
+				return fmt.Errorf("orca: truncated fixed64 field %d", num)
+			}
+			v := math.Float64frombits(binary.LittleEndian.Uint64(b))
+			b = b[8:]
+			switch num {
+			case fieldCPUUtilization:
+				lr.CPUUtilization = v
+			case fieldMemUtilization:
+				lr.MemUtilization = v
+			case fieldRPSFractional:
+				lr.RPSFractional = v
+			}
+		case wireTypeBytes:
+			ln, n, err := consumeVarint(b)
+			if err != nil {
+				return err
+			}
+			b = b[n:]
+			if uint64(len(b)) < ln {
+				return fmt.Errorf("orca: truncated bytes field %d", num)
+			}
+			entry := b[:ln]
+			b = b[ln:]
+			switch num {
+			case fieldRequestCost:
+				if err := unmarshalMapEntry(entry, &lr.RequestCost); err != nil {
+					return err
+				}
+			case fieldUtilization:
+				if err := unmarshalMapEntry(entry, &lr.Utilization); err != nil {
+					return err
+				}
+			case fieldNamedMetrics:
+				if err := unmarshalMapEntry(entry, &lr.NamedMetrics); err != nil {
+					return err
+				}
+			}
+		case wireTypeFixed32:
+			if len(b) < 4 {
+				return fmt.Errorf("orca: truncated fixed32 field %d", num)
+			}
+			b = b[4:]
+		default:
+			// Unknown wire type (e.g. a deprecated group start/end marker):
+			// there is no generic way to determine its length, so skip the
+			// rest of the message rather than fail it outright.
+			return nil
+		}
+	}
+	return nil
+}
+
+func appendFixed64Field(b []byte, field int, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendVarint(b, uint64(field)<<3|wireTypeFixed64)
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+	return append(b, buf[:]...)
+}
+
+func appendMapField(b []byte, field int, m map[string]float64) []byte {
+	for k, v := range m {
+		entry := appendStringField(nil, 1, k)
+		entry = appendFixed64Field(entry, 2, v)
+		b = appendVarint(b, uint64(field)<<3|wireTypeBytes)
+		b = appendVarint(b, uint64(len(entry)))
+		b = append(b, entry...)
+	}
+	return b
+}
+
+func appendStringField(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = appendVarint(b, uint64(field)<<3|wireTypeBytes)
+	b = appendVarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func consumeVarint(b []byte) (uint64, int, error) {
+	var v uint64
+	for i := 0; i < len(b); i++ {
+		v |= uint64(b[i]&0x7f) << (7 * uint(i))
+		if b[i] < 0x80 {
+			return v, i + 1, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("orca: truncated varint")
+}
+
+func consumeTag(b []byte) (field, wireType int, n int, err error) {
+	v, n, err := consumeVarint(b)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return int(v >> 3), int(v & 0x7), n, nil
+}
+
+func unmarshalMapEntry(b []byte, m *map[string]float64) error {
+	var key string
+	var val float64
+	for len(b) > 0 {
+		num, wt, n, err := consumeTag(b)
+		if err != nil {
+			return err
+		}
+		b = b[n:]
+		switch wt {
+		case wireTypeVarint:
+			_, n, err := consumeVarint(b)
+			if err != nil {
+				return err
+			}
+			b = b[n:]
+		case wireTypeBytes:
+			ln, n, err := consumeVarint(b)
+			if err != nil {
+				return err
+			}
+			b = b[n:]
+			if uint64(len(b)) < ln {
+				return fmt.Errorf("orca: truncated map entry")
+			}
+			if num == 1 {
+				key = string(b[:ln])
+			}
+			b = b[ln:]
+		case wireTypeFixed64:
+			if len(b) < 8 {
+				return fmt.Errorf("orca: truncated map entry value")
+			}
+			if num == 2 {
+				val = math.Float64frombits(binary.LittleEndian.Uint64(b))
+			}
+			b = b[8:]
+		case wireTypeFixed32:
+			if len(b) < 4 {
+				return fmt.Errorf("orca: truncated map entry value")
+			}
+			b = b[4:]
+		default:
+			// Unknown wire type within a map entry: no generic way to skip
+			// it, so stop processing this entry rather than fail the whole
+			// report.
+			b = nil
+		}
+	}
+	if *m == nil {
+		*m = make(map[string]float64)
+	}
+	(*m)[key] = val
+	return nil
+}
@@ -0,0 +1,99 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package orca implements Open Request Cost Aggregation, which is used to
+// report load/utilization data from a gRPC server to its clients. Load can
+// be reported in the trailing metadata of individual RPCs, or via a
+// dedicated out-of-band streaming RPC (see Register for the server side and
+// OOBListener and RegisterOOBListener for the client side).
+package orca
+
+import (
+	"context"
+
+	"google.golang.org/grpc/internal/balancerload"
+	"google.golang.org/grpc/metadata"
+)
+
+// TrailerMetadataKey is the key of the trailer metadata used to transmit an
+// ORCA load report from a server to its clients at the end of an RPC. The
+// value is the wire-encoded LoadReport, corresponding to the
+// xds.data.orca.v3.OrcaLoadReport proto message.
+const TrailerMetadataKey = "endpoint-load-metrics-bin"
+
+// CallMetricRecorder is the interface used by a gRPC server handler to
+// report per-call cost and utilization metrics that are sent back to the
+// client in the trailing metadata of the RPC. It is installed into the
+// context of every RPC handled by a server configured via
+// CallMetricsServerOption, and can be retrieved with
+// CallMetricRecorderFromContext.
+type CallMetricRecorder interface {
+	// SetCPUUtilization sets the CPU utilization for the call.
+	SetCPUUtilization(val float64) CallMetricRecorder
+	// SetMemoryUtilization sets the memory utilization for the call.
+	SetMemoryUtilization(val float64) CallMetricRecorder
+	// SetUtilization sets the value for a generic application-defined
+	// utilization metric identified by name.
+	SetUtilization(name string, val float64) CallMetricRecorder
+	// DeleteUtilization removes any previously-set value for the named
+	// utilization metric.
+	DeleteUtilization(name string) CallMetricRecorder
+	// SetRequestCost sets the value for a request cost metric identified by
+	// name.
+	SetRequestCost(name string, val float64) CallMetricRecorder
+	// DeleteRequestCost removes any previously-set value for the named
+	// request cost metric.
+	DeleteRequestCost(name string) CallMetricRecorder
+}
+
+type callMetricRecorderKey struct{}
+
+// CallMetricRecorderFromContext returns the CallMetricRecorder stored in
+// ctx, or nil, false if the server handling the RPC was not configured with
+// CallMetricsServerOption.
+func CallMetricRecorderFromContext(ctx context.Context) (CallMetricRecorder, bool) {
+	smr, ok := ctx.Value(callMetricRecorderKey{}).(*recorder)
+	if !ok {
+		return nil, false
+	}
+	return smr, true
+}
+
+func newContextWithRecorder(ctx context.Context, r *recorder) context.Context {
+	return context.WithValue(ctx, callMetricRecorderKey{}, r)
+}
+
+func init() {
+	balancerload.SetParser(loadParser{})
+}
+
+// loadParser decodes the ORCA load report trailer into a *LoadReport for
+// consumption by balancer.DoneInfo.ServerLoad.
+type loadParser struct{}
+
+func (loadParser) Parse(md metadata.MD) interface{} {
+	vs := md.Get(TrailerMetadataKey)
+	if len(vs) == 0 {
+		return nil
+	}
+	lr := &LoadReport{}
+	if err := lr.Unmarshal([]byte(vs[0])); err != nil {
+		return nil
+	}
+	return lr
+}
@@ -0,0 +1,196 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package orca
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestLoadReportMarshalUnmarshal(t *testing.T) {
+	want := &LoadReport{
+		CPUUtilization: 0.1,
+		MemUtilization: 0.2,
+		RPSFractional:  12.5,
+		RequestCost:    map[string]float64{"db_queries": 3},
+		Utilization:    map[string]float64{"queue_depth": 0.4},
+		NamedMetrics:   map[string]float64{"cache_hit_rate": 0.9},
+	}
+	b, err := want.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	got := &LoadReport{}
+	if err := got.Unmarshal(b); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip = %+v, want %+v", got, want)
+	}
+}
+
+func TestDefaultParser(t *testing.T) {
+	lr := &LoadReport{CPUUtilization: 0.5, RPSFractional: 10}
+	b, err := lr.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	md := metadata.Pairs(TrailerMetadataKey, string(b))
+	got, ok := loadParser{}.Parse(md).(*LoadReport)
+	if !ok {
+		t.Fatalf("loadParser.Parse() did not return a *LoadReport")
+	}
+	if got.CPUUtilization != lr.CPUUtilization || got.RPSFractional != lr.RPSFractional {
+		t.Errorf("parsed load report = %+v, want %+v", got, lr)
+	}
+}
+
+// fakeOOBStream implements enough of grpc.ClientStream to drive
+// oobProducer.runStream through a scripted sequence of LoadReports.
+type fakeOOBStream struct {
+	grpc.ClientStream
+	reports []*LoadReport
+	i       int
+}
+
+func (f *fakeOOBStream) SendMsg(m interface{}) error { return nil }
+func (f *fakeOOBStream) CloseSend() error            { return nil }
+func (f *fakeOOBStream) RecvMsg(m interface{}) error {
+	if f.i >= len(f.reports) {
+		return io.EOF
+	}
+	*(m.(*LoadReport)) = *f.reports[f.i]
+	f.i++
+	return nil
+}
+
+// fakeOOBClientConn implements grpc.ClientConnInterface on top of a series
+// of scripted fakeOOBStreams, standing in for the handle a real SubConn's
+// GetOrBuildProducer would hand back. Each NewStream call advances to the
+// next scripted stream, so a test can drive oobProducer through more than
+// one reconnect cycle; the last stream is reused for any further calls.
+type fakeOOBClientConn struct {
+	streams []*fakeOOBStream
+	i       int
+}
+
+func (f *fakeOOBClientConn) Invoke(ctx context.Context, method string, args, reply interface{}, opts ...grpc.CallOption) error {
+	return fmt.Errorf("Invoke not implemented")
+}
+
+func (f *fakeOOBClientConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	s := f.streams[f.i]
+	if f.i < len(f.streams)-1 {
+		f.i++
+	}
+	return s, nil
+}
+
+// fakeOOBSubConn is a balancer.SubConn whose GetOrBuildProducer forwards
+// straight to the given grpc.ClientConnInterface, as a real SubConn's would
+// once it has an established transport.
+type fakeOOBSubConn struct {
+	balancer.SubConn
+	cc grpc.ClientConnInterface
+}
+
+func (f *fakeOOBSubConn) GetOrBuildProducer(pb balancer.ProducerBuilder) (balancer.Producer, func()) {
+	return pb.Build(f.cc)
+}
+
+// fakeOOBListener records every LoadReport it receives and signals got after
+// each one.
+type fakeOOBListener struct {
+	got chan struct{}
+
+	mu      sync.Mutex
+	reports []*LoadReport
+}
+
+func (f *fakeOOBListener) OnLoadReport(lr *LoadReport) {
+	f.mu.Lock()
+	f.reports = append(f.reports, lr)
+	f.mu.Unlock()
+	f.got <- struct{}{}
+}
+
+func TestRegisterOOBListener(t *testing.T) {
+	stream := &fakeOOBStream{reports: []*LoadReport{
+		{CPUUtilization: 0.5},
+		{CPUUtilization: 0.7},
+	}}
+	sc := &fakeOOBSubConn{cc: &fakeOOBClientConn{streams: []*fakeOOBStream{stream}}}
+	l := &fakeOOBListener{got: make(chan struct{}, len(stream.reports))}
+
+	stop := RegisterOOBListener(sc, l, OOBListenerOptions{ReportInterval: time.Millisecond})
+	defer stop()
+
+	for i := range stream.reports {
+		select {
+		case <-l.got:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for load report %d", i)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.reports) != len(stream.reports) {
+		t.Fatalf("got %d reports, want %d", len(l.reports), len(stream.reports))
+	}
+	for i, want := range stream.reports {
+		if l.reports[i].CPUUtilization != want.CPUUtilization {
+			t.Errorf("report[%d].CPUUtilization = %v, want %v", i, l.reports[i].CPUUtilization, want.CPUUtilization)
+		}
+	}
+}
+
+// TestRunStreamCleanEndResetsBackoff drives oobProducer.runStream through
+// two reconnect cycles, each ending cleanly (io.EOF) after delivering a
+// report. Both cycles must report (streamed=true, err=nil): run's backoff
+// reset (`err == nil && streamed`) depends on a clean stream end being
+// translated away from io.EOF, not surfaced as an error that would keep
+// the backoff counter climbing for the life of the SubConn.
+func TestRunStreamCleanEndResetsBackoff(t *testing.T) {
+	cc := &fakeOOBClientConn{streams: []*fakeOOBStream{
+		{reports: []*LoadReport{{CPUUtilization: 0.5}}},
+		{reports: []*LoadReport{{CPUUtilization: 0.6}}},
+	}}
+	l := &fakeOOBListener{got: make(chan struct{}, 2)}
+	p := &oobProducer{cc: cc, listener: l, interval: time.Millisecond}
+
+	for cycle := 0; cycle < 2; cycle++ {
+		streamed, err := p.runStream(context.Background())
+		if err != nil {
+			t.Fatalf("runStream() cycle %d returned err = %v, want nil", cycle, err)
+		}
+		if !streamed {
+			t.Fatalf("runStream() cycle %d streamed = false, want true", cycle)
+		}
+	}
+}
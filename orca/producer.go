@@ -0,0 +1,142 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package orca
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/internal/backoff"
+)
+
+// OOBListener is implemented by balancers that want to receive periodic,
+// out-of-band load reports for a SubConn, independent of any individual
+// RPC's trailing metadata.
+type OOBListener interface {
+	// OnLoadReport is invoked with each LoadReport received on the
+	// out-of-band metrics stream for the SubConn passed to
+	// RegisterOOBListener.
+	OnLoadReport(*LoadReport)
+}
+
+// OOBListenerOptions configures a call to RegisterOOBListener.
+type OOBListenerOptions struct {
+	// ReportInterval is the requested interval between load reports. The
+	// server may enforce a larger minimum.
+	ReportInterval time.Duration
+}
+
+// RegisterOOBListener subscribes to periodic out-of-band load reports on
+// sc's connection and invokes listener.OnLoadReport with each one received,
+// until the returned stop function is called. sc must already be in (or
+// reach) state connectivity.Ready; RegisterOOBListener is a no-op until then and
+// automatically re-subscribes, with exponential backoff, if the stream
+// errors or the SubConn reconnects.
+func RegisterOOBListener(sc balancer.SubConn, listener OOBListener, opts OOBListenerOptions) (stop func()) {
+	pr, closeProducer := sc.GetOrBuildProducer(producerBuilderSingleton)
+	cc := pr.(*ccProducer).cc
+	p := &oobProducer{cc: cc, listener: listener, interval: opts.ReportInterval}
+	ctx, cancel := context.WithCancel(context.Background())
+	go p.run(ctx)
+	return func() {
+		cancel()
+		closeProducer()
+	}
+}
+
+// producerBuilderSingleton is the balancer.ProducerBuilder RegisterOOBListener
+// passes to SubConn.GetOrBuildProducer, the sanctioned way to obtain an
+// RPC-capable handle bound to a SubConn's transport (a raw balancer.SubConn
+// does not itself implement grpc.ClientConnInterface).
+var producerBuilderSingleton = &producerBuilder{}
+
+type producerBuilder struct{}
+
+// Build implements balancer.ProducerBuilder.
+func (*producerBuilder) Build(cc grpc.ClientConnInterface) (balancer.Producer, func()) {
+	return &ccProducer{cc: cc}, func() {}
+}
+
+// ccProducer is the balancer.Producer wrapping the grpc.ClientConnInterface
+// bound to a SubConn's transport. It holds no state of its own; each
+// RegisterOOBListener call opens its own oobProducer stream over cc.
+type ccProducer struct {
+	cc grpc.ClientConnInterface
+}
+
+// oobProducer owns a single client stream to a SubConn's
+// OpenRcaService/StreamCoreMetrics, reconnecting with backoff on failure and
+// forwarding every received LoadReport to listener.
+type oobProducer struct {
+	cc       grpc.ClientConnInterface
+	listener OOBListener
+	interval time.Duration
+}
+
+func (p *oobProducer) run(ctx context.Context) {
+	bs := backoff.DefaultExponential
+	retries := 0
+	for ctx.Err() == nil {
+		streamed, err := p.runStream(ctx)
+		if err == nil && streamed {
+			retries = 0
+		} else {
+			retries++
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(bs.Backoff(retries)):
+		}
+	}
+}
+
+// runStream opens the streaming RPC on sc's underlying connection and reads
+// LoadReports from it until the stream ends or ctx is canceled. streamed
+// reports whether at least one LoadReport was delivered before the stream
+// ended, so run can tell a productive connection (worth resetting the
+// backoff for) from one that failed or ended before ever delivering
+// anything.
+func (p *oobProducer) runStream(ctx context.Context) (streamed bool, err error) {
+	desc := &grpc.StreamDesc{StreamName: "StreamCoreMetrics", ServerStreams: true}
+	stream, err := p.cc.NewStream(ctx, desc, "/grpc.orca.v3.OpenRcaService/StreamCoreMetrics")
+	if err != nil {
+		return false, err
+	}
+	if err := stream.SendMsg(&OrcaLoadReportRequest{ReportInterval: p.interval}); err != nil {
+		return false, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return false, err
+	}
+	for {
+		lr := new(LoadReport)
+		if err := stream.RecvMsg(lr); err != nil {
+			if err == io.EOF {
+				return streamed, nil
+			}
+			return streamed, err
+		}
+		streamed = true
+		p.listener.OnLoadReport(lr)
+	}
+}
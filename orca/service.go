@@ -0,0 +1,230 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package orca
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ServerMetricsProvider is implemented by applications that want to supply
+// the periodic, out-of-band load reports served by the OpenRcaService. It is
+// separate from the per-call CallMetricRecorder: the metrics that request
+// handlers record there go only to that RPC's trailer, so a provider
+// supplies its own server-wide values, e.g. a host-level aggregate.
+type ServerMetricsProvider interface {
+	// ServerMetrics returns the current snapshot of server-wide metrics.
+	ServerMetrics() *LoadReport
+}
+
+// ServiceOptions configures a Service registered via Register.
+type ServiceOptions struct {
+	// MinReportingInterval is the lower bound enforced on the interval
+	// requested by a client for out-of-band load reports. Defaults to 30s if
+	// unset or smaller than 30s, matching the ORCA service default.
+	MinReportingInterval time.Duration
+}
+
+const defaultMinReportingInterval = 30 * time.Second
+
+// Service implements the OpenRcaService out-of-band metrics streaming
+// service (grpc.orca.v3.OpenRcaService/StreamCoreMetrics).
+type Service struct {
+	provider    ServerMetricsProvider
+	minInterval time.Duration
+}
+
+// Register constructs a Service backed by provider and registers it on s.
+func Register(s *grpc.Server, provider ServerMetricsProvider, opts ServiceOptions) *Service {
+	min := opts.MinReportingInterval
+	if min < defaultMinReportingInterval {
+		min = defaultMinReportingInterval
+	}
+	svc := &Service{provider: provider, minInterval: min}
+	s.RegisterService(&serviceDesc, svc)
+	return svc
+}
+
+// OrcaLoadReportRequest is the request message for StreamCoreMetrics,
+// corresponding to xds.service.orca.v3.OrcaLoadReportRequest.
+type OrcaLoadReportRequest struct {
+	// ReportInterval is how often the client wants to receive a report.
+	ReportInterval time.Duration
+}
+
+// proto field number for xds.service.orca.v3.OrcaLoadReportRequest. Field 1,
+// report_interval, is itself a nested google.protobuf.Duration message,
+// whose own field numbers (seconds=1, nanos=2) are given below.
+const fieldReportInterval = 1
+
+const (
+	durationFieldSeconds = 1
+	durationFieldNanos   = 2
+)
+
+// Reset, String, and ProtoMessage implement proto.Message, so that
+// *OrcaLoadReportRequest can be passed directly to stream.SendMsg/RecvMsg;
+// see the identical rationale on LoadReport in load_report.go.
+func (r *OrcaLoadReportRequest) Reset()         { *r = OrcaLoadReportRequest{} }
+func (r *OrcaLoadReportRequest) String() string { return fmt.Sprintf("%+v", *r) }
+func (r *OrcaLoadReportRequest) ProtoMessage()  {}
+
+// Marshal encodes r using the protobuf wire format of
+// xds.service.orca.v3.OrcaLoadReportRequest.
+func (r *OrcaLoadReportRequest) Marshal() ([]byte, error) {
+	if r.ReportInterval == 0 {
+		return nil, nil
+	}
+	var dur []byte
+	secs := int64(r.ReportInterval / time.Second)
+	nanos := int32(r.ReportInterval % time.Second)
+	if secs != 0 {
+		dur = appendVarint(dur, uint64(durationFieldSeconds)<<3|wireTypeVarint)
+		dur = appendVarint(dur, uint64(secs))
+	}
+	if nanos != 0 {
+		dur = appendVarint(dur, uint64(durationFieldNanos)<<3|wireTypeVarint)
+		dur = appendVarint(dur, uint64(uint32(nanos)))
+	}
+	var b []byte
+	b = appendVarint(b, uint64(fieldReportInterval)<<3|wireTypeBytes)
+	b = appendVarint(b, uint64(len(dur)))
+	b = append(b, dur...)
+	return b, nil
+}
+
+// Unmarshal decodes b, the wire format of
+// xds.service.orca.v3.OrcaLoadReportRequest, into r.
+func (r *OrcaLoadReportRequest) Unmarshal(b []byte) error {
+	for len(b) > 0 {
+		num, wt, n, err := consumeTag(b)
+		if err != nil {
+			return err
+		}
+		b = b[n:]
+		if wt != wireTypeBytes {
+			return fmt.Errorf("orca: unsupported wire type %d for field %d", wt, num)
+		}
+		ln, n, err := consumeVarint(b)
+		if err != nil {
+			return err
+		}
+		b = b[n:]
+		if uint64(len(b)) < ln {
+			return fmt.Errorf("orca: truncated bytes field %d", num)
+		}
+		entry := b[:ln]
+		b = b[ln:]
+		if num == fieldReportInterval {
+			secs, nanos, err := unmarshalDuration(entry)
+			if err != nil {
+				return err
+			}
+			r.ReportInterval = time.Duration(secs)*time.Second + time.Duration(nanos)
+		}
+	}
+	return nil
+}
+
+func unmarshalDuration(b []byte) (secs int64, nanos int32, err error) {
+	for len(b) > 0 {
+		num, wt, n, err := consumeTag(b)
+		if err != nil {
+			return 0, 0, err
+		}
+		b = b[n:]
+		if wt != wireTypeVarint {
+			return 0, 0, fmt.Errorf("orca: unsupported wire type %d in Duration", wt)
+		}
+		v, n, err := consumeVarint(b)
+		if err != nil {
+			return 0, 0, err
+		}
+		b = b[n:]
+		switch num {
+		case durationFieldSeconds:
+			secs = int64(v)
+		case durationFieldNanos:
+			nanos = int32(uint32(v))
+		}
+	}
+	return secs, nanos, nil
+}
+
+// StreamCoreMetricsServer is the server-side streaming handle for
+// StreamCoreMetrics.
+type StreamCoreMetricsServer interface {
+	Send(*LoadReport) error
+	grpc.ServerStream
+}
+
+// streamCoreMetrics serves periodic snapshots of svc.provider's metrics,
+// honoring the interval requested by the client (clamped to minInterval)
+// until the stream's context is done.
+func (svc *Service) streamCoreMetrics(req *OrcaLoadReportRequest, stream StreamCoreMetricsServer) error {
+	interval := req.ReportInterval
+	if interval < svc.minInterval {
+		interval = svc.minInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := stream.Send(svc.provider.ServerMetrics()); err != nil {
+			return err
+		}
+		select {
+		case <-stream.Context().Done():
+			return status.Error(codes.Canceled, stream.Context().Err().Error())
+		case <-ticker.C:
+		}
+	}
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: "grpc.orca.v3.OpenRcaService",
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamCoreMetrics",
+			Handler:       streamCoreMetricsHandler,
+			ServerStreams: true,
+		},
+	},
+}
+
+func streamCoreMetricsHandler(srv interface{}, stream grpc.ServerStream) error {
+	svc := srv.(*Service)
+	req := new(OrcaLoadReportRequest)
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	return svc.streamCoreMetrics(req, &streamCoreMetricsServerImpl{ServerStream: stream})
+}
+
+type streamCoreMetricsServerImpl struct {
+	grpc.ServerStream
+}
+
+func (s *streamCoreMetricsServerImpl) Send(lr *LoadReport) error {
+	return s.ServerStream.SendMsg(lr)
+}
@@ -34,9 +34,9 @@ import (
 	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/grpclog"
-	"google.golang.org/grpc/internal/balancerload"
 	"google.golang.org/grpc/internal/testutils"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/orca"
 	"google.golang.org/grpc/resolver"
 	"google.golang.org/grpc/resolver/manual"
 	"google.golang.org/grpc/status"
@@ -212,22 +212,6 @@ func testDoneInfo(t *testing.T, e env) {
 	}
 }
 
-const loadMDKey = "X-Endpoint-Load-Metrics-Bin"
-
-type testLoadParser struct{}
-
-func (*testLoadParser) Parse(md metadata.MD) interface{} {
-	vs := md.Get(loadMDKey)
-	if len(vs) == 0 {
-		return nil
-	}
-	return vs[0]
-}
-
-func init() {
-	balancerload.SetParser(&testLoadParser{})
-}
-
 func (s) TestDoneLoads(t *testing.T) {
 	for _, e := range listTestEnv() {
 		testDoneLoads(t, e)
@@ -238,15 +222,26 @@ func testDoneLoads(t *testing.T, e env) {
 	b := &testBalancer{}
 	balancer.Register(b)
 
-	const testLoad = "test-load-,-should-be-orca"
+	wantLoad := &orca.LoadReport{
+		CPUUtilization: 0.812,
+		MemUtilization: 0.503,
+		RequestCost:    map[string]float64{"db_queries": 17},
+	}
 
 	ss := &stubServer{
 		emptyCall: func(ctx context.Context, in *testpb.Empty) (*testpb.Empty, error) {
-			grpc.SetTrailer(ctx, metadata.Pairs(loadMDKey, testLoad))
+			smr, ok := orca.CallMetricRecorderFromContext(ctx)
+			if !ok {
+				return nil, status.Error(codes.Internal, "no call metric recorder in context")
+			}
+			smr.SetCPUUtilization(wantLoad.CPUUtilization).SetMemoryUtilization(wantLoad.MemUtilization)
+			for k, v := range wantLoad.RequestCost {
+				smr.SetRequestCost(k, v)
+			}
 			return &testpb.Empty{}, nil
 		},
 	}
-	if err := ss.Start(nil, grpc.WithBalancerName(testBalancerName)); err != nil {
+	if err := ss.Start(orca.CallMetricsServerOption(), grpc.WithBalancerName(testBalancerName)); err != nil {
 		t.Fatalf("error starting testing server: %v", err)
 	}
 	defer ss.Stop()
@@ -269,9 +264,9 @@ func testDoneLoads(t *testing.T, e env) {
 	if len(b.doneInfo) < 1 {
 		t.Fatalf("b.doneInfo = %v, want length 1", b.doneInfo)
 	}
-	gotLoad, _ := b.doneInfo[0].ServerLoad.(string)
-	if gotLoad != testLoad {
-		t.Fatalf("b.doneInfo[0].ServerLoad = %v; want = %v", b.doneInfo[0].ServerLoad, testLoad)
+	gotLoad, _ := b.doneInfo[0].ServerLoad.(*orca.LoadReport)
+	if gotLoad == nil || gotLoad.CPUUtilization != wantLoad.CPUUtilization || gotLoad.MemUtilization != wantLoad.MemUtilization || gotLoad.RequestCost["db_queries"] != 17 {
+		t.Fatalf("b.doneInfo[0].ServerLoad = %+v; want = %+v", gotLoad, wantLoad)
 	}
 }
 